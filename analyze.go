@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagAnalyzePercentiles string
+	flagAnalyzeWindow      string
+	flagAnalyzeSparkline   bool
+
+	cmdAnalyze = &cobra.Command{
+		Use:     "analyze TRACE-FILE",
+		Short:   "Recompute a summary from a trace captured with --trace-file",
+		Example: "rkt-monitor analyze run1.trace --percentiles=50,95,99 --window=5s",
+		Args:    cobra.ExactArgs(1),
+		Run:     runAnalyze,
+	}
+)
+
+func init() {
+	cmdAnalyze.Flags().StringVar(&flagAnalyzePercentiles, "percentiles", "50,95,99", "Comma-separated RSS/CPU percentiles to report")
+	cmdAnalyze.Flags().StringVar(&flagAnalyzeWindow, "window", "5s", "Rolling window size for windowed avg/peak aggregates")
+	cmdAnalyze.Flags().BoolVar(&flagAnalyzeSparkline, "sparkline", false, "Render an ASCII RSS sparkline per PID")
+
+	cmdRktMonitor.AddCommand(cmdAnalyze)
+}
+
+func runAnalyze(cmd *cobra.Command, args []string) {
+	samples, err := readTraceFile(args[0])
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+
+	percentiles, err := parsePercentiles(flagAnalyzePercentiles)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+
+	window, err := time.ParseDuration(flagAnalyzeWindow)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+
+	byPid := make(map[int32][]Sample)
+	for _, s := range samples {
+		byPid[s.Pid] = append(byPid[s.Pid], s)
+	}
+
+	for pid, ss := range byPid {
+		sort.Slice(ss, func(i, j int) bool { return ss[i].Time.Before(ss[j].Time) })
+
+		var sumRSS uint64
+		var peakRSS uint64
+		var sumCPU float64
+		rssValues := make([]float64, len(ss))
+		cpuValues := make([]float64, len(ss))
+		for i, s := range ss {
+			sumRSS += s.RSSBytes
+			if s.RSSBytes > peakRSS {
+				peakRSS = s.RSSBytes
+			}
+			sumCPU += s.CPUPercent
+			rssValues[i] = float64(s.RSSBytes)
+			cpuValues[i] = s.CPUPercent
+		}
+		avgRSS := sumRSS / uint64(len(ss))
+		avgCPU := sumCPU / float64(len(ss))
+
+		fmt.Printf("%s(%d): samples: %d  avg CPU: %f%%  avg Mem: %s  peak Mem: %s\n", ss[0].Name, pid, len(ss), avgCPU, formatSize(avgRSS), formatSize(peakRSS))
+
+		sorted := append([]float64(nil), rssValues...)
+		sort.Float64s(sorted)
+		sortedCPU := append([]float64(nil), cpuValues...)
+		sort.Float64s(sortedCPU)
+		for _, p := range percentiles {
+			fmt.Printf("  p%g Mem: %s  CPU: %f%%\n", p, formatSize(uint64(percentile(sorted, p))), percentile(sortedCPU, p))
+		}
+
+		for _, w := range rollingWindows(ss, window) {
+			var wSum, wPeak uint64
+			for _, s := range w {
+				wSum += s.RSSBytes
+				if s.RSSBytes > wPeak {
+					wPeak = s.RSSBytes
+				}
+			}
+			fmt.Printf("  window %s: avg Mem: %s  peak Mem: %s\n", w[0].Time.Format(time.RFC3339), formatSize(wSum/uint64(len(w))), formatSize(wPeak))
+		}
+
+		if flagAnalyzeSparkline {
+			fmt.Printf("  Mem: %s\n", sparkline(rssValues))
+		}
+	}
+}
+
+// parsePercentiles parses a comma-separated list like "50,95,99".
+func parsePercentiles(s string) ([]float64, error) {
+	var percentiles []float64
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		p, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid percentile %q: %v", f, err)
+		}
+		percentiles = append(percentiles, p)
+	}
+	return percentiles, nil
+}
+
+// percentile returns the nearest-rank percentile p (0-100) of sorted, which
+// must already be sorted ascending.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted))/100)) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// rollingWindows buckets samples (already sorted by time) into consecutive,
+// non-overlapping windows of the given size.
+func rollingWindows(samples []Sample, window time.Duration) [][]Sample {
+	if len(samples) == 0 {
+		return nil
+	}
+	var windows [][]Sample
+	bucketEnd := samples[0].Time.Add(window)
+	var current []Sample
+	for _, s := range samples {
+		if s.Time.After(bucketEnd) {
+			windows = append(windows, current)
+			current = nil
+			bucketEnd = s.Time.Add(window)
+		}
+		current = append(current, s)
+	}
+	if len(current) > 0 {
+		windows = append(windows, current)
+	}
+	return windows
+}
+
+var sparkChars = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline renders values as a single line of block characters scaled
+// between their min and max.
+func sparkline(values []float64) string {
+	if len(values) == 0 {
+		return ""
+	}
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		if max == min {
+			b.WriteRune(sparkChars[0])
+			continue
+		}
+		idx := int((v - min) / (max - min) * float64(len(sparkChars)-1))
+		b.WriteRune(sparkChars[idx])
+	}
+	return b.String()
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,6 +15,17 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// processAccumulator tracks running avg/peak stats for a single process
+// across the monitoring window without retaining per-sample history, so
+// --duration can be set arbitrarily high without growing memory.
+type processAccumulator struct {
+	Name    string
+	Count   uint64
+	SumCPU  float64
+	SumRSS  uint64
+	PeakRSS uint64
+}
+
 type ProcessStatus struct {
 	Pid  int32
 	Name string  // Name of process
@@ -25,8 +38,18 @@ type ProcessStatus struct {
 var (
 	pidMap map[int32]*process.Process
 
-	flagVerbose  bool
-	flagDuration string
+	flagVerbose         bool
+	flagDuration        string
+	flagSource          string
+	flagOutput          string
+	flagOutputURL       string
+	flagMaxRSS          string
+	flagMaxPeakRSS      string
+	flagMaxAvgCPU       float64
+	flagWaitListen      string
+	flagWaitTimeout     string
+	flagMeasureDuration string
+	flagTraceFile       string
 
 	cmdRktMonitor = &cobra.Command{
 		Use:     "rkt-monitor IMAGE",
@@ -41,6 +64,16 @@ func init() {
 
 	cmdRktMonitor.Flags().BoolVarP(&flagVerbose, "verbose", "v", false, "Print current usage every second")
 	cmdRktMonitor.Flags().StringVarP(&flagDuration, "duration", "d", "10s", "How long to run the ACI")
+	cmdRktMonitor.Flags().StringVar(&flagSource, "source", "procfs", "Where to read usage from: \"procfs\" (sum per-PID RSS/VMS) or \"cgroup\" (read pod-level accounting straight from the machine-rkt cgroup)")
+	cmdRktMonitor.Flags().StringVar(&flagOutput, "output", "stdout", "Where to stream samples: \"stdout\" (JSON lines), \"influxdb\" (line protocol over HTTP), or \"prometheus\" (textfile collector dump)")
+	cmdRktMonitor.Flags().StringVar(&flagOutputURL, "output-url", "", "Destination for --output: an InfluxDB /write URL, or a textfile collector path for prometheus")
+	cmdRktMonitor.Flags().StringVar(&flagMaxRSS, "max-rss", "", "Fail if any process's (or the pod's) average RSS exceeds this, e.g. \"512MiB\"")
+	cmdRktMonitor.Flags().StringVar(&flagMaxPeakRSS, "max-peak-rss", "", "Fail if any process's (or the pod's) peak RSS exceeds this, e.g. \"1GiB\"")
+	cmdRktMonitor.Flags().Float64Var(&flagMaxAvgCPU, "max-avg-cpu", 0, "Fail if any process's average CPU percent exceeds this, e.g. 80")
+	cmdRktMonitor.Flags().StringVar(&flagWaitListen, "wait-listen", "", "Comma-separated port(s) to wait for a child of rkt to LISTEN on before starting the measurement window, instead of measuring from process start")
+	cmdRktMonitor.Flags().StringVar(&flagWaitTimeout, "wait-timeout", "30s", "How long to wait for --wait-listen before giving up")
+	cmdRktMonitor.Flags().StringVar(&flagMeasureDuration, "measure-duration", "", "How long to measure steady-state usage once ready; defaults to --duration")
+	cmdRktMonitor.Flags().StringVar(&flagTraceFile, "trace-file", "", "Record every sample as a length-prefixed JSON trace to this path, for later \"rkt-monitor analyze\"")
 }
 
 func main() {
@@ -59,7 +92,59 @@ func runRktMonitor(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	execCmd := exec.Command("rkt", "run", args[0], "--insecure-options=image", "--net=host")
+	var maxRSS, maxPeakRSS uint64
+	if flagMaxRSS != "" {
+		if maxRSS, err = parseSize(flagMaxRSS); err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+	}
+	if flagMaxPeakRSS != "" {
+		if maxPeakRSS, err = parseSize(flagMaxPeakRSS); err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	measureDuration := d
+	if flagMeasureDuration != "" {
+		if measureDuration, err = time.ParseDuration(flagMeasureDuration); err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var waitPorts []uint32
+	var waitTimeout time.Duration
+	if flagWaitListen != "" {
+		if waitPorts, err = parsePorts(flagWaitListen); err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+		if waitTimeout, err = time.ParseDuration(flagWaitTimeout); err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var uuidFile string
+	rktArgs := []string{"run", args[0], "--insecure-options=image", "--net=host"}
+	if flagSource == "cgroup" {
+		f, err := ioutil.TempFile("", "rkt-monitor-uuid")
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+		f.Close()
+		uuidFile = f.Name()
+		defer os.Remove(uuidFile)
+		rktArgs = append(rktArgs, "--uuid-file-save="+uuidFile)
+	} else if flagSource != "procfs" {
+		fmt.Printf("unknown --source %q, must be \"procfs\" or \"cgroup\"\n", flagSource)
+		os.Exit(1)
+	}
+
+	execCmd := exec.Command("rkt", rktArgs...)
 	err = execCmd.Start()
 	if err != nil {
 		fmt.Printf("%v\n", err)
@@ -78,9 +163,50 @@ func runRktMonitor(cmd *cobra.Command, args []string) {
 		}
 	}()
 
-	usages := make(map[int32][]*ProcessStatus)
+	var podUUID string
+	if flagSource == "cgroup" {
+		podUUID, err = waitForPodUUID(uuidFile, 10*time.Second)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			if killErr := killAllChildren(int32(execCmd.Process.Pid)); killErr != nil {
+				fmt.Fprintf(os.Stderr, "cleanup failed: %v\n", killErr)
+			}
+			os.Exit(1)
+		}
+	}
+
+	if len(waitPorts) > 0 {
+		readyAfter, err := waitForListen(int32(execCmd.Process.Pid), waitPorts, waitTimeout)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			if killErr := killAllChildren(int32(execCmd.Process.Pid)); killErr != nil {
+				fmt.Fprintf(os.Stderr, "cleanup failed: %v\n", killErr)
+			}
+			os.Exit(1)
+		}
+		fmt.Printf("ready after %s\n", readyAfter)
+	}
+
+	sink, err := newSink(flagOutput, flagOutputURL)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+
+	var trace *traceWriter
+	if flagTraceFile != "" {
+		trace, err = newTraceWriter(flagTraceFile)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	stats := make(map[int32]*processAccumulator)
+	podAcc := &podCgroupAccumulator{}
+	sysAcc := &systemAccumulator{}
 
-	timeToStop := time.Now().Add(d)
+	timeToStop := time.Now().Add(measureDuration)
 	for time.Now().Before(timeToStop) {
 		usage, err := getUsage(int32(execCmd.Process.Pid))
 		if err != nil {
@@ -91,7 +217,58 @@ func runRktMonitor(cmd *cobra.Command, args []string) {
 		}
 
 		for _, ps := range usage {
-			usages[ps.Pid] = append(usages[ps.Pid], ps)
+			acc, ok := stats[ps.Pid]
+			if !ok {
+				acc = &processAccumulator{Name: ps.Name}
+				stats[ps.Pid] = acc
+			}
+			acc.Count++
+			acc.SumCPU += ps.CPU
+			acc.SumRSS += ps.RSS
+			if ps.RSS > acc.PeakRSS {
+				acc.PeakRSS = ps.RSS
+			}
+
+			sample := Sample{
+				Time:       time.Now(),
+				PodUUID:    podUUID,
+				Pid:        ps.Pid,
+				Name:       ps.Name,
+				CPUPercent: ps.CPU,
+				RSSBytes:   ps.RSS,
+				VMSBytes:   ps.VMS,
+				SwapBytes:  ps.Swap,
+			}
+			if err := sink.Write(sample); err != nil {
+				fmt.Fprintf(os.Stderr, "sink write failed: %v\n", err)
+			}
+			if trace != nil {
+				if err := trace.Write(sample); err != nil {
+					fmt.Fprintf(os.Stderr, "trace write failed: %v\n", err)
+				}
+			}
+		}
+
+		sys, err := getSystemStatus()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "reading system status: %v\n", err)
+		} else {
+			sysAcc.Add(sys)
+			if flagVerbose {
+				printSystemStatus(sys)
+			}
+		}
+
+		if flagSource == "cgroup" {
+			cgStats, err := getPodCgroupStats(podUUID)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "reading cgroup stats: %v\n", err)
+			} else {
+				podAcc.Add(cgStats)
+				if flagVerbose {
+					printPodStats(cgStats)
+				}
+			}
 		}
 
 		_, err = process.NewProcess(int32(execCmd.Process.Pid))
@@ -109,24 +286,66 @@ func runRktMonitor(cmd *cobra.Command, args []string) {
 		fmt.Fprintf(os.Stderr, "cleanup failed: %v\n", err)
 	}
 
-	for _, processHistory := range usages {
-		var avgCPU float64
-		var avgMem uint64
-		var peakMem uint64
-
-		for _, p := range processHistory {
-			avgCPU += p.CPU
-			avgMem += p.RSS
-			if peakMem < p.RSS {
-				peakMem = p.RSS
-			}
+	if err := sink.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "closing sink: %v\n", err)
+	}
+	if trace != nil {
+		if err := trace.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "closing trace file: %v\n", err)
 		}
+	}
 
-		avgCPU = avgCPU / float64(len(processHistory))
-		avgMem = avgMem / uint64(len(processHistory))
+	for pid, acc := range stats {
+		avgCPU := acc.SumCPU / float64(acc.Count)
+		avgMem := acc.SumRSS / acc.Count
 
-		fmt.Printf("%s(%d): seconds alive: %d  avg CPU: %f%%  avg Mem: %s  peak Mem: %s\n", processHistory[0].Name, processHistory[0].Pid, len(processHistory), avgCPU, formatSize(avgMem), formatSize(peakMem))
+		fmt.Printf("%s(%d): seconds alive: %d  avg CPU: %f%%  avg Mem: %s  peak Mem: %s\n", acc.Name, pid, acc.Count, avgCPU, formatSize(avgMem), formatSize(acc.PeakRSS))
 	}
+
+	if podAcc.Count > 0 {
+		avgUsage := podAcc.SumUsage / podAcc.Count
+		fmt.Printf("pod: samples: %d  avg Mem: %s  peak Mem: %s  peak max_usage: %s  CPU time: %s  blkio: %s\n",
+			podAcc.Count, formatSize(avgUsage), formatSize(podAcc.PeakUsage), formatSize(podAcc.PeakMaxUsage),
+			time.Duration(podAcc.LastCPUUsage), formatSize(podAcc.LastBlkioBytes))
+	}
+
+	if sysAcc.Count > 0 {
+		avgLoad1 := sysAcc.SumLoad1 / float64(sysAcc.Count)
+		avgCPU := sysAcc.SumCPU / float64(sysAcc.Count)
+		avgMemUsedPct := sysAcc.SumMemUsedPct / float64(sysAcc.Count)
+
+		fmt.Printf("host: samples: %d  avg load1: %.2f  avg CPU: %.1f%%  avg Mem used: %.1f%%\n", sysAcc.Count, avgLoad1, avgCPU, avgMemUsedPct)
+	}
+
+	if violations := checkThresholds(stats, podAcc, maxRSS, maxPeakRSS, flagMaxAvgCPU); len(violations) > 0 {
+		for _, v := range violations {
+			fmt.Fprintf(os.Stderr, "threshold violated: %s\n", v)
+		}
+		os.Exit(1)
+	}
+}
+
+// waitForPodUUID polls the uuid file rkt writes via --uuid-file-save until it
+// contains a UUID and the pod's cgroup hierarchy has been created, or until
+// timeout elapses.
+func waitForPodUUID(uuidFile string, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		b, err := ioutil.ReadFile(uuidFile)
+		if err == nil && len(b) > 0 {
+			uuid := strings.TrimSpace(string(b))
+			if podCgroupReady(uuid) {
+				return uuid, nil
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return "", fmt.Errorf("timed out waiting for pod cgroup to appear")
+}
+
+func printPodStats(s *PodCgroupStats) {
+	fmt.Printf("pod: Mem: %s (rss: %s cache: %s swap: %s) CPU: %s\n",
+		formatSize(s.MemUsage), formatSize(s.MemRSS), formatSize(s.MemCache), formatSize(s.MemSwap), time.Duration(s.CPUUsage))
 }
 
 func killAllChildren(pid int32) error {
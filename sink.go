@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Sample is a single point-in-time measurement of one process's resource
+// usage, tagged with enough identifying information for a Sink to route or
+// label it.
+type Sample struct {
+	Time       time.Time `json:"time"`
+	PodUUID    string    `json:"pod_uuid,omitempty"`
+	Pid        int32     `json:"pid"`
+	Name       string    `json:"name"`
+	CPUPercent float64   `json:"cpu_percent"`
+	RSSBytes   uint64    `json:"rss_bytes"`
+	VMSBytes   uint64    `json:"vms_bytes"`
+	SwapBytes  uint64    `json:"swap_bytes"`
+}
+
+// Sink is the destination samples are streamed to as they're collected, one
+// per process per poll. Implementations must not buffer unboundedly, so that
+// long-running benchmarks don't grow memory without bound.
+type Sink interface {
+	Write(sample Sample) error
+	Close() error
+}
+
+// newSink constructs the Sink named by output, pointed at outputURL where
+// applicable.
+func newSink(output, outputURL string) (Sink, error) {
+	switch output {
+	case "", "stdout":
+		return &stdoutSink{}, nil
+	case "influxdb":
+		if outputURL == "" {
+			return nil, fmt.Errorf("--output=influxdb requires --output-url")
+		}
+		return &influxSink{url: outputURL}, nil
+	case "prometheus":
+		if outputURL == "" {
+			return nil, fmt.Errorf("--output=prometheus requires --output-url to be a textfile collector path")
+		}
+		return &prometheusTextfileSink{path: outputURL, metrics: make(map[string]Sample)}, nil
+	default:
+		return nil, fmt.Errorf("unknown --output %q, must be one of: stdout, influxdb, prometheus", output)
+	}
+}
+
+// stdoutSink writes each sample as a JSON object, one per line.
+type stdoutSink struct{}
+
+func (s *stdoutSink) Write(sample Sample) error {
+	b, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func (s *stdoutSink) Close() error { return nil }
+
+// influxSink POSTs each sample to an InfluxDB /write endpoint as a line
+// protocol point in the "rkt_monitor" measurement.
+type influxSink struct {
+	url string
+}
+
+func (s *influxSink) Write(sample Sample) error {
+	// Line protocol rejects empty tag values, so pod_uuid is only tagged when
+	// the pod UUID is known (it is empty with --source=procfs).
+	tags := fmt.Sprintf("pid=%d,name=%s", sample.Pid, escapeTag(sample.Name))
+	if sample.PodUUID != "" {
+		tags = "pod_uuid=" + escapeTag(sample.PodUUID) + "," + tags
+	}
+	line := fmt.Sprintf(
+		"rkt_monitor,%s cpu_percent=%f,rss_bytes=%d,vms_bytes=%d,swap_bytes=%d %d\n",
+		tags,
+		sample.CPUPercent, sample.RSSBytes, sample.VMSBytes, sample.SwapBytes,
+		sample.Time.UnixNano(),
+	)
+	resp, err := http.Post(s.url, "text/plain", bytes.NewBufferString(line))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("influxdb write to %s: status %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s *influxSink) Close() error { return nil }
+
+func escapeTag(s string) string {
+	r := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' || s[i] == ',' || s[i] == '=' {
+			r = append(r, '\\')
+		}
+		r = append(r, s[i])
+	}
+	return string(r)
+}
+
+// prometheusMetricTTL bounds how long a PID that's stopped reporting (the
+// process exited) is still rendered into the textfile, so short-lived
+// children don't leave stale gauges, and the metrics map doesn't grow
+// unboundedly over a long benchmark.
+const prometheusMetricTTL = 5 * time.Second
+
+// prometheusTextfileSink keeps the latest sample per PID and rewrites a
+// node_exporter textfile collector file on every Write, since that collector
+// expects the file to hold current gauges rather than an append-only log.
+type prometheusTextfileSink struct {
+	path    string
+	metrics map[string]Sample
+}
+
+func (s *prometheusTextfileSink) Write(sample Sample) error {
+	key := fmt.Sprintf("%d", sample.Pid)
+	s.metrics[key] = sample
+
+	for k, m := range s.metrics {
+		if sample.Time.Sub(m.Time) > prometheusMetricTTL {
+			delete(s.metrics, k)
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# TYPE rkt_monitor_cpu_percent gauge\n")
+	for _, m := range s.metrics {
+		fmt.Fprintf(&buf, "rkt_monitor_cpu_percent{pod_uuid=%q,pid=\"%d\",name=%q} %f\n", m.PodUUID, m.Pid, m.Name, m.CPUPercent)
+	}
+	fmt.Fprintf(&buf, "# TYPE rkt_monitor_rss_bytes gauge\n")
+	for _, m := range s.metrics {
+		fmt.Fprintf(&buf, "rkt_monitor_rss_bytes{pod_uuid=%q,pid=\"%d\",name=%q} %d\n", m.PodUUID, m.Pid, m.Name, m.RSSBytes)
+	}
+	fmt.Fprintf(&buf, "# TYPE rkt_monitor_vms_bytes gauge\n")
+	for _, m := range s.metrics {
+		fmt.Fprintf(&buf, "rkt_monitor_vms_bytes{pod_uuid=%q,pid=\"%d\",name=%q} %d\n", m.PodUUID, m.Pid, m.Name, m.VMSBytes)
+	}
+	fmt.Fprintf(&buf, "# TYPE rkt_monitor_swap_bytes gauge\n")
+	for _, m := range s.metrics {
+		fmt.Fprintf(&buf, "rkt_monitor_swap_bytes{pod_uuid=%q,pid=\"%d\",name=%q} %d\n", m.PodUUID, m.Pid, m.Name, m.SwapBytes)
+	}
+
+	return ioutil.WriteFile(s.path, buf.Bytes(), 0644)
+}
+
+func (s *prometheusTextfileSink) Close() error { return nil }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/host"
+	"github.com/shirou/gopsutil/load"
+	"github.com/shirou/gopsutil/mem"
+)
+
+// SystemStatus is a snapshot of host-wide load, memory, CPU, and uptime,
+// sampled once per poll alongside the per-process ProcessStatus numbers so
+// spikes in rkt's own usage can be weighed against host pressure rather than
+// attributed to the workload outright.
+type SystemStatus struct {
+	LoadAvg1   float64
+	LoadAvg5   float64
+	LoadAvg15  float64
+	MemTotal   uint64
+	MemUsed    uint64
+	MemUsedPct float64
+	CPUPercent float64
+	UptimeSecs uint64
+}
+
+func getSystemStatus() (*SystemStatus, error) {
+	l, err := load.Avg()
+	if err != nil {
+		return nil, err
+	}
+	vm, err := mem.VirtualMemory()
+	if err != nil {
+		return nil, err
+	}
+	cpuPercents, err := cpu.Percent(0, false)
+	if err != nil {
+		return nil, err
+	}
+	var cpuPercent float64
+	if len(cpuPercents) > 0 {
+		cpuPercent = cpuPercents[0]
+	}
+	info, err := host.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SystemStatus{
+		LoadAvg1:   l.Load1,
+		LoadAvg5:   l.Load5,
+		LoadAvg15:  l.Load15,
+		MemTotal:   vm.Total,
+		MemUsed:    vm.Used,
+		MemUsedPct: vm.UsedPercent,
+		CPUPercent: cpuPercent,
+		UptimeSecs: info.Uptime,
+	}, nil
+}
+
+// systemAccumulator tracks running avg host stats across the monitoring
+// window without retaining per-sample history, the same way
+// processAccumulator does for per-process stats.
+type systemAccumulator struct {
+	Count         uint64
+	SumLoad1      float64
+	SumCPU        float64
+	SumMemUsedPct float64
+}
+
+func (a *systemAccumulator) Add(s *SystemStatus) {
+	a.Count++
+	a.SumLoad1 += s.LoadAvg1
+	a.SumCPU += s.CPUPercent
+	a.SumMemUsedPct += s.MemUsedPct
+}
+
+func printSystemStatus(s *SystemStatus) {
+	fmt.Printf("host: load: %.2f %.2f %.2f  mem: %s/%s (%.1f%%)  CPU: %.1f%%  uptime: %s\n",
+		s.LoadAvg1, s.LoadAvg5, s.LoadAvg15,
+		formatSize(s.MemUsed), formatSize(s.MemTotal), s.MemUsedPct,
+		s.CPUPercent, time.Duration(s.UptimeSecs)*time.Second)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var sizeRE = regexp.MustCompile(`(?i)^([0-9]+(?:\.[0-9]+)?)\s*(B|KiB|MiB|GiB)?$`)
+
+// parseSize parses a human-provided size like "512MiB", "1GiB", or a bare
+// byte count, using binary (1024-based) units to match formatSize's output.
+func parseSize(s string) (uint64, error) {
+	m := sizeRE.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q, expected e.g. \"512MiB\" or \"1GiB\"", s)
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	switch strings.ToUpper(m[2]) {
+	case "", "B":
+		return uint64(n), nil
+	case "KIB":
+		return uint64(n * 1024), nil
+	case "MIB":
+		return uint64(n * 1024 * 1024), nil
+	case "GIB":
+		return uint64(n * 1024 * 1024 * 1024), nil
+	default:
+		return 0, fmt.Errorf("invalid size unit in %q", s)
+	}
+}
+
+// checkThresholds compares the per-process accumulators and the pod-level
+// aggregate against the --max-* limits, returning one message per violation.
+// An empty result means the run is within its resource envelope.
+func checkThresholds(stats map[int32]*processAccumulator, podAcc *podCgroupAccumulator, maxRSS, maxPeakRSS uint64, maxAvgCPU float64) []string {
+	var violations []string
+
+	for pid, acc := range stats {
+		avgMem := acc.SumRSS / acc.Count
+		avgCPU := acc.SumCPU / float64(acc.Count)
+
+		if maxRSS > 0 && avgMem > maxRSS {
+			violations = append(violations, fmt.Sprintf("%s(%d): avg RSS %s exceeds --max-rss %s", acc.Name, pid, formatSize(avgMem), formatSize(maxRSS)))
+		}
+		if maxPeakRSS > 0 && acc.PeakRSS > maxPeakRSS {
+			violations = append(violations, fmt.Sprintf("%s(%d): peak RSS %s exceeds --max-peak-rss %s", acc.Name, pid, formatSize(acc.PeakRSS), formatSize(maxPeakRSS)))
+		}
+		if maxAvgCPU > 0 && avgCPU > maxAvgCPU {
+			violations = append(violations, fmt.Sprintf("%s(%d): avg CPU %.1f%% exceeds --max-avg-cpu %.1f%%", acc.Name, pid, avgCPU, maxAvgCPU))
+		}
+	}
+
+	if podAcc.Count > 0 {
+		avgUsage := podAcc.SumUsage / podAcc.Count
+
+		if maxRSS > 0 && avgUsage > maxRSS {
+			violations = append(violations, fmt.Sprintf("pod: avg Mem %s exceeds --max-rss %s", formatSize(avgUsage), formatSize(maxRSS)))
+		}
+		if maxPeakRSS > 0 && podAcc.PeakMaxUsage > maxPeakRSS {
+			violations = append(violations, fmt.Sprintf("pod: peak max_usage %s exceeds --max-peak-rss %s", formatSize(podAcc.PeakMaxUsage), formatSize(maxPeakRSS)))
+		}
+	}
+
+	return violations
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// traceWriter persists every Sample as a length-prefixed JSON record, so a
+// run captured on a CI node can be replayed and diffed later with
+// "rkt-monitor analyze", independent of live collection.
+type traceWriter struct {
+	f *os.File
+}
+
+func newTraceWriter(path string) (*traceWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &traceWriter{f: f}, nil
+}
+
+func (t *traceWriter) Write(sample Sample) error {
+	b, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(b)))
+	if _, err := t.f.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	_, err = t.f.Write(b)
+	return err
+}
+
+func (t *traceWriter) Close() error {
+	return t.f.Close()
+}
+
+// readTraceFile reads back every Sample written by a traceWriter.
+func readTraceFile(path string) ([]Sample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var samples []Sample
+	for {
+		var lenBuf [4]byte
+		_, err := io.ReadFull(f, lenBuf[:])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, binary.BigEndian.Uint32(lenBuf[:]))
+		if _, err := io.ReadFull(f, buf); err != nil {
+			return nil, err
+		}
+
+		var s Sample
+		if err := json.Unmarshal(buf, &s); err != nil {
+			return nil, err
+		}
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	gopsnet "github.com/shirou/gopsutil/net"
+	"github.com/shirou/gopsutil/process"
+)
+
+// parsePorts parses a comma-separated "--wait-listen" value like "80,8080"
+// into the list of ports to watch for.
+func parsePorts(s string) ([]uint32, error) {
+	var ports []uint32
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		n, err := strconv.ParseUint(f, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q in --wait-listen: %v", f, err)
+		}
+		ports = append(ports, uint32(n))
+	}
+	return ports, nil
+}
+
+// descendantPids returns pid and all of its descendants, walking the process
+// tree the same way getUsage does.
+func descendantPids(pid int32) ([]int32, error) {
+	pids := []int32{pid}
+	for i := 0; i < len(pids); i++ {
+		proc, err := process.NewProcess(pids[i])
+		if err != nil {
+			continue
+		}
+		children, err := proc.Children()
+		if err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok && exitErr.Sys().(syscall.WaitStatus).ExitStatus() == 1 {
+				continue
+			}
+			return nil, err
+		}
+	childloop:
+		for _, child := range children {
+			for _, p := range pids {
+				if p == child.Pid {
+					continue childloop
+				}
+			}
+			pids = append(pids, child.Pid)
+		}
+	}
+	return pids, nil
+}
+
+// waitForListen polls until a descendant of pid is LISTENing on one of
+// ports, or returns an error once timeout elapses. It returns how long the
+// wait took.
+func waitForListen(pid int32, ports []uint32, timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	deadline := start.Add(timeout)
+
+	for time.Now().Before(deadline) {
+		pids, err := descendantPids(pid)
+		if err == nil {
+			conns, err := gopsnet.Connections("all")
+			if err == nil {
+				pidSet := make(map[int32]bool, len(pids))
+				for _, p := range pids {
+					pidSet[p] = true
+				}
+				portSet := make(map[uint32]bool, len(ports))
+				for _, p := range ports {
+					portSet[p] = true
+				}
+				for _, c := range conns {
+					if c.Status == "LISTEN" && pidSet[c.Pid] && portSet[c.Laddr.Port] {
+						return time.Since(start), nil
+					}
+				}
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return time.Since(start), fmt.Errorf("timed out after %s waiting for a listener on port(s) %v", timeout, ports)
+}